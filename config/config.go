@@ -0,0 +1,86 @@
+// Package config loads the YAML test-plan file that drives
+// serial_stresstest, so a run can be described declaratively and reused in
+// CI instead of hard-coded in main.
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestParams controls how a single run exercises a port: the payload
+// length range to search, how many samples to take for delay reporting,
+// how many repetitions a candidate must pass before being accepted (Load
+// defaults this to 1 if omitted, since 0 would accept every candidate
+// without ever exercising the port), how long to wait for a response
+// before treating it as a failure, and whether to transmit in both
+// directions at once.
+type TestParams struct {
+	MinPayloadLength int           `yaml:"min_payload_length"`
+	MaxPayloadLength int           `yaml:"max_payload_length"`
+	Samples          int           `yaml:"samples"`
+	Retries          int           `yaml:"retries"`
+	Timeout          time.Duration `yaml:"timeout"`
+	FullDuplex       bool          `yaml:"full_duplex"`
+}
+
+// PortPair is one transmit/receive path to exercise. RX equal to TX means
+// a single physical port with a hardware loopback; a distinct RX means two
+// ports joined by a crossover cable.
+type PortPair struct {
+	TX string `yaml:"tx"`
+	RX string `yaml:"rx"`
+}
+
+// Output controls where results are written.
+type Output struct {
+	// JSONLPath is the file structured result lines are appended to. Empty
+	// means results are only logged, not persisted.
+	JSONLPath string `yaml:"jsonl_path"`
+}
+
+// Plan describes a full test run: which ports (or explicit tx/rx pairs) to
+// exercise, which baud rates are candidates for the speed search, the
+// shared TestParams, and where to send results.
+type Plan struct {
+	Ports     []string   `yaml:"ports"`
+	PortPairs []PortPair `yaml:"port_pairs"`
+	BaudRates []int      `yaml:"baud_rates"`
+	Test      TestParams `yaml:"test"`
+	Output    Output     `yaml:"output"`
+}
+
+// Load reads and parses the YAML test plan at path. If PortPairs is empty,
+// it is filled in from Ports as self-loopback pairs (TX == RX), so a plain
+// list of ports still works for single-port hardware loopback.
+func Load(path string) (Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Plan{}, fmt.Errorf("config: reading %s: %w", path, err)
+	}
+
+	var plan Plan
+	if err := yaml.Unmarshal(data, &plan); err != nil {
+		return Plan{}, fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+
+	if len(plan.PortPairs) == 0 {
+		for _, port := range plan.Ports {
+			plan.PortPairs = append(plan.PortPairs, PortPair{TX: port, RX: port})
+		}
+	}
+	if len(plan.PortPairs) == 0 {
+		return Plan{}, fmt.Errorf("config: %s: no ports or port_pairs configured", path)
+	}
+	if len(plan.BaudRates) == 0 {
+		return Plan{}, fmt.Errorf("config: %s: no baud_rates configured", path)
+	}
+	if plan.Test.Retries <= 0 {
+		plan.Test.Retries = 1
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const validPlan = `
+ports:
+  - /dev/ttyUSB0
+  - /dev/ttyUSB1
+baud_rates: [9600, 115200]
+test:
+  min_payload_length: 10
+  max_payload_length: 1000
+  samples: 20
+  retries: 3
+  timeout: 500ms
+output:
+  jsonl_path: results.jsonl
+`
+
+func writePlan(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "plan.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	plan, err := Load(writePlan(t, validPlan))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(plan.Ports) != 2 || plan.Ports[0] != "/dev/ttyUSB0" {
+		t.Errorf("Ports = %v, want [/dev/ttyUSB0 /dev/ttyUSB1]", plan.Ports)
+	}
+	wantPairs := []PortPair{{TX: "/dev/ttyUSB0", RX: "/dev/ttyUSB0"}, {TX: "/dev/ttyUSB1", RX: "/dev/ttyUSB1"}}
+	if len(plan.PortPairs) != len(wantPairs) || plan.PortPairs[0] != wantPairs[0] || plan.PortPairs[1] != wantPairs[1] {
+		t.Errorf("PortPairs = %v, want %v (self-loopback pairs derived from Ports)", plan.PortPairs, wantPairs)
+	}
+	if len(plan.BaudRates) != 2 || plan.BaudRates[1] != 115200 {
+		t.Errorf("BaudRates = %v, want [9600 115200]", plan.BaudRates)
+	}
+	if plan.Test.Timeout != 500*time.Millisecond {
+		t.Errorf("Test.Timeout = %v, want 500ms", plan.Test.Timeout)
+	}
+	if plan.Output.JSONLPath != "results.jsonl" {
+		t.Errorf("Output.JSONLPath = %q, want %q", plan.Output.JSONLPath, "results.jsonl")
+	}
+}
+
+func TestLoadMissingPorts(t *testing.T) {
+	if _, err := Load(writePlan(t, "baud_rates: [9600]\n")); err == nil {
+		t.Fatal("Load() error = nil, want error for missing ports")
+	}
+}
+
+func TestLoadDefaultsMissingRetries(t *testing.T) {
+	plan, err := Load(writePlan(t, "ports: [/dev/ttyUSB0]\nbaud_rates: [9600]\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if plan.Test.Retries != 1 {
+		t.Errorf("Test.Retries = %d, want 1 (defaulted), so a candidate is never accepted without being probed", plan.Test.Retries)
+	}
+}
+
+func TestLoadExplicitPortPairs(t *testing.T) {
+	plan, err := Load(writePlan(t, `
+port_pairs:
+  - tx: /dev/ttyUSB0
+    rx: /dev/ttyUSB1
+  - tx: /dev/ttyUSB1
+    rx: /dev/ttyUSB0
+baud_rates: [9600]
+test:
+  full_duplex: true
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	want := []PortPair{{TX: "/dev/ttyUSB0", RX: "/dev/ttyUSB1"}, {TX: "/dev/ttyUSB1", RX: "/dev/ttyUSB0"}}
+	if len(plan.PortPairs) != len(want) || plan.PortPairs[0] != want[0] || plan.PortPairs[1] != want[1] {
+		t.Errorf("PortPairs = %v, want %v (explicit pairs, not derived from Ports)", plan.PortPairs, want)
+	}
+	if !plan.Test.FullDuplex {
+		t.Error("Test.FullDuplex = false, want true")
+	}
+}
+
+func TestLoadMissingBaudRates(t *testing.T) {
+	if _, err := Load(writePlan(t, "ports: [/dev/ttyUSB0]\n")); err == nil {
+		t.Fatal("Load() error = nil, want error for missing baud_rates")
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("Load() error = nil, want error for missing file")
+	}
+}
@@ -0,0 +1,89 @@
+// Package delaystats turns a slice of round-trip delay samples into a
+// percentile report, since a plain min/mean/max hides the tail stalls that
+// actually matter on a serial link.
+package delaystats
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/montanaflynn/stats"
+)
+
+// DelayReport summarises a set of round-trip delay samples, including the
+// tail percentiles that a bare min/mean/max would hide.
+type DelayReport struct {
+	Min     time.Duration
+	Mean    time.Duration
+	Median  time.Duration
+	P90     time.Duration
+	P95     time.Duration
+	P99     time.Duration
+	Max     time.Duration
+	StdDev  time.Duration
+	Samples []time.Duration
+}
+
+// Report computes a DelayReport from samples. It returns an error if
+// samples is empty, since percentiles are undefined for an empty set.
+func Report(samples []time.Duration) (DelayReport, error) {
+	if len(samples) == 0 {
+		return DelayReport{}, fmt.Errorf("delaystats: no samples")
+	}
+
+	data := make(stats.Float64Data, len(samples))
+	for i, s := range samples {
+		data[i] = float64(s)
+	}
+
+	min, err := data.Min()
+	if err != nil {
+		return DelayReport{}, err
+	}
+	mean, err := data.Mean()
+	if err != nil {
+		return DelayReport{}, err
+	}
+	median, err := data.Median()
+	if err != nil {
+		return DelayReport{}, err
+	}
+	p90, err := data.Percentile(90)
+	if err != nil {
+		return DelayReport{}, err
+	}
+	p95, err := data.Percentile(95)
+	if err != nil {
+		return DelayReport{}, err
+	}
+	p99, err := data.Percentile(99)
+	if err != nil {
+		return DelayReport{}, err
+	}
+	max, err := data.Max()
+	if err != nil {
+		return DelayReport{}, err
+	}
+	stdDev, err := data.StandardDeviation()
+	if err != nil {
+		return DelayReport{}, err
+	}
+
+	return DelayReport{
+		Min:     time.Duration(min),
+		Mean:    time.Duration(mean),
+		Median:  time.Duration(median),
+		P90:     time.Duration(p90),
+		P95:     time.Duration(p95),
+		P99:     time.Duration(p99),
+		Max:     time.Duration(max),
+		StdDev:  time.Duration(stdDev),
+		Samples: samples,
+	}, nil
+}
+
+// Summary renders a compact histogram-style line: min, p50, p90, p95, p99, max.
+func (r DelayReport) Summary() string {
+	return fmt.Sprintf("min=%s p50=%s p90=%s p95=%s p99=%s max=%s",
+		r.Min, r.Median, r.P90, r.P95, r.P99, r.Max)
+}
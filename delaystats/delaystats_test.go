@@ -0,0 +1,50 @@
+package delaystats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	samples := []time.Duration{
+		1 * time.Millisecond,
+		2 * time.Millisecond,
+		3 * time.Millisecond,
+		4 * time.Millisecond,
+		10 * time.Millisecond,
+	}
+
+	r, err := Report(samples)
+	if err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+
+	if r.Min != 1*time.Millisecond {
+		t.Errorf("Min = %s, want 1ms", r.Min)
+	}
+	if r.Max != 10*time.Millisecond {
+		t.Errorf("Max = %s, want 10ms", r.Max)
+	}
+	if len(r.Samples) != len(samples) {
+		t.Errorf("len(Samples) = %d, want %d", len(r.Samples), len(samples))
+	}
+	if r.P99 < r.Median {
+		t.Errorf("P99 = %s, want >= Median %s", r.P99, r.Median)
+	}
+}
+
+func TestReportEmpty(t *testing.T) {
+	if _, err := Report(nil); err == nil {
+		t.Fatal("Report(nil) error = nil, want error")
+	}
+}
+
+func TestSummaryFormat(t *testing.T) {
+	r, err := Report([]time.Duration{1 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if got := r.Summary(); got == "" {
+		t.Fatalf("Summary() = %q, want non-empty", got)
+	}
+}
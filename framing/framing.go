@@ -0,0 +1,218 @@
+// Package framing implements a mark/escape framed protocol for the serial
+// stress tester. Payload bytes are escaped so that a reserved frame
+// delimiter can never appear inside a frame, which lets the reader
+// resynchronise after noise or a partial read instead of depending on a
+// fixed-size buffer.
+package framing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// Mark terminates a frame. It never appears unescaped inside a frame.
+	Mark byte = 0x0A
+	// Esc escapes an occurrence of Mark or Esc inside the payload.
+	Esc byte = 0x5E
+	// escXor is xored into an escaped byte so that the escaped form never
+	// collides with Mark or Esc itself.
+	escXor byte = 0x20
+)
+
+var crcTable = crc32.MakeTable(0xEDB88320)
+
+// Errors returned by Decoder.ReadFrame.
+var (
+	ErrShortFrame   = errors.New("framing: frame shorter than checksum")
+	ErrBadChecksum  = errors.New("framing: checksum mismatch")
+	ErrEscapeAtEOF  = errors.New("framing: escape byte at end of frame")
+	ErrUnrecognised = errors.New("framing: unrecognised message code")
+)
+
+// Code identifies the kind of Message carried by a frame.
+type Code byte
+
+const (
+	// CodeHeartbeat is a keepalive with no meaningful payload.
+	CodeHeartbeat Code = iota + 1
+	// CodeEcho asks the peer to return the payload unchanged as a Pong.
+	CodeEcho
+	// CodePong is the reply to an Echo.
+	CodePong
+	// CodePayload carries a stress-test payload and its expected checksum.
+	CodePayload
+)
+
+// Message is anything that can be framed and sent over the wire. Code
+// identifies how the dispatcher and peer should interpret Payload.
+type Message interface {
+	Code() Code
+	Payload() []byte
+}
+
+// Heartbeat is a keepalive message with no payload.
+type Heartbeat struct{}
+
+func (Heartbeat) Code() Code      { return CodeHeartbeat }
+func (Heartbeat) Payload() []byte { return nil }
+
+// Echo asks the peer to return Data unchanged as a Pong.
+type Echo struct{ Data []byte }
+
+func (m Echo) Code() Code      { return CodeEcho }
+func (m Echo) Payload() []byte { return m.Data }
+
+// Pong is the reply to an Echo, carrying the same Data back.
+type Pong struct{ Data []byte }
+
+func (m Pong) Code() Code      { return CodePong }
+func (m Pong) Payload() []byte { return m.Data }
+
+// Payload carries arbitrary stress-test data.
+type Payload struct{ Data []byte }
+
+func (m Payload) Code() Code      { return CodePayload }
+func (m Payload) Payload() []byte { return m.Data }
+
+// Encode serialises msg as Code followed by its Payload, appends a CRC-32
+// checksum, escapes any occurrence of Mark or Esc in the result, and
+// terminates the frame with an unescaped Mark.
+func Encode(msg Message) []byte {
+	body := append([]byte{byte(msg.Code())}, msg.Payload()...)
+
+	checksum := crc32.Checksum(body, crcTable)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], checksum)
+	body = append(body, sum[:]...)
+
+	framed := make([]byte, 0, len(body)+2)
+	for _, b := range body {
+		if b == Mark || b == Esc {
+			framed = append(framed, Esc, b^escXor)
+		} else {
+			framed = append(framed, b)
+		}
+	}
+	framed = append(framed, Mark)
+
+	return framed
+}
+
+// Decode interprets a frame returned by Decoder.ReadFrame (Code byte
+// followed by the message payload, checksum already stripped and
+// verified) as a typed Message.
+func Decode(frame []byte) (Message, error) {
+	if len(frame) < 1 {
+		return nil, ErrShortFrame
+	}
+
+	payload := append([]byte(nil), frame[1:]...)
+	switch Code(frame[0]) {
+	case CodeHeartbeat:
+		return Heartbeat{}, nil
+	case CodeEcho:
+		return Echo{Data: payload}, nil
+	case CodePong:
+		return Pong{Data: payload}, nil
+	case CodePayload:
+		return Payload{Data: payload}, nil
+	default:
+		return nil, ErrUnrecognised
+	}
+}
+
+// Stats counts frame-level events observed by a Decoder, so callers can
+// surface protocol health (e.g. in test reports) without re-deriving it
+// from error values alone.
+type Stats struct {
+	Received     int // frames successfully decoded and checksum-verified
+	Escaped      int // escaped bytes unescaped across all frames
+	CheckErrors  int // frames dropped for a checksum mismatch
+	ShortFrame   int // frames dropped for being shorter than a checksum
+	Unrecognised int // frames with a Code that Decode does not know
+}
+
+// Decoder reads Mark-delimited, escape-encoded frames off an io.Reader and
+// tracks Stats about what it has seen.
+type Decoder struct {
+	r     *bufio.Reader
+	Stats Stats
+}
+
+// NewDecoder returns a Decoder that reads frames from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// ReadFrame reads bytes until an unescaped Mark, unescaping as it goes, and
+// returns the frame with its trailing checksum verified and stripped. It
+// returns ErrShortFrame, ErrBadChecksum or ErrEscapeAtEOF for a malformed
+// frame, or the underlying read error (e.g. io.EOF) if the stream ends
+// before a Mark is seen.
+func (d *Decoder) ReadFrame() ([]byte, error) {
+	var body []byte
+	escaping := false
+
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			if escaping && errors.Is(err, io.EOF) {
+				return nil, ErrEscapeAtEOF
+			}
+			return nil, err
+		}
+
+		if escaping {
+			body = append(body, b^escXor)
+			escaping = false
+			d.Stats.Escaped++
+			continue
+		}
+
+		switch b {
+		case Esc:
+			escaping = true
+		case Mark:
+			return d.verify(body)
+		default:
+			body = append(body, b)
+		}
+	}
+}
+
+func (d *Decoder) verify(body []byte) ([]byte, error) {
+	if len(body) < 4 {
+		d.Stats.ShortFrame++
+		return nil, ErrShortFrame
+	}
+
+	payload, sum := body[:len(body)-4], body[len(body)-4:]
+	want := binary.BigEndian.Uint32(sum)
+	got := crc32.Checksum(payload, crcTable)
+	if want != got {
+		d.Stats.CheckErrors++
+		return nil, ErrBadChecksum
+	}
+
+	if len(payload) < 1 || !validCode(Code(payload[0])) {
+		d.Stats.Unrecognised++
+		return nil, ErrUnrecognised
+	}
+
+	d.Stats.Received++
+	return payload, nil
+}
+
+// validCode reports whether c is a Code that Decode knows how to dispatch.
+func validCode(c Code) bool {
+	switch c {
+	case CodeHeartbeat, CodeEcho, CodePong, CodePayload:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,115 @@
+package framing
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	cases := []Message{
+		Heartbeat{},
+		Echo{Data: []byte("ping")},
+		Pong{Data: []byte("ping")},
+		Payload{Data: []byte{0x00, Mark, Esc, 0xff, Mark, Mark}},
+	}
+
+	for _, msg := range cases {
+		framed := Encode(msg)
+		if framed[len(framed)-1] != Mark {
+			t.Fatalf("Encode(%v): frame not terminated with Mark: %x", msg, framed)
+		}
+
+		d := NewDecoder(bytes.NewReader(framed))
+		frame, err := d.ReadFrame()
+		if err != nil {
+			t.Fatalf("ReadFrame(%v): unexpected error: %v", msg, err)
+		}
+
+		got, err := Decode(frame)
+		if err != nil {
+			t.Fatalf("Decode(%v): unexpected error: %v", msg, err)
+		}
+		if got.Code() != msg.Code() || !bytes.Equal(got.Payload(), msg.Payload()) {
+			t.Fatalf("round trip mismatch: got %+v, want %+v", got, msg)
+		}
+		if d.Stats.Received != 1 {
+			t.Fatalf("Stats.Received = %d, want 1", d.Stats.Received)
+		}
+	}
+}
+
+func TestReadFrameBadChecksum(t *testing.T) {
+	framed := Encode(Payload{Data: []byte("hello")})
+	framed[0] ^= 0xff // corrupt the code/payload without touching the checksum
+
+	d := NewDecoder(bytes.NewReader(framed))
+	_, err := d.ReadFrame()
+	if !errors.Is(err, ErrBadChecksum) {
+		t.Fatalf("ReadFrame() error = %v, want ErrBadChecksum", err)
+	}
+	if d.Stats.CheckErrors != 1 {
+		t.Fatalf("Stats.CheckErrors = %d, want 1", d.Stats.CheckErrors)
+	}
+}
+
+func TestReadFrameShort(t *testing.T) {
+	d := NewDecoder(bytes.NewReader([]byte{0x01, 0x02, Mark}))
+	_, err := d.ReadFrame()
+	if !errors.Is(err, ErrShortFrame) {
+		t.Fatalf("ReadFrame() error = %v, want ErrShortFrame", err)
+	}
+	if d.Stats.ShortFrame != 1 {
+		t.Fatalf("Stats.ShortFrame = %d, want 1", d.Stats.ShortFrame)
+	}
+}
+
+func TestReadFrameEscapeAtEOF(t *testing.T) {
+	d := NewDecoder(bytes.NewReader([]byte{0x01, Esc}))
+	_, err := d.ReadFrame()
+	if !errors.Is(err, ErrEscapeAtEOF) {
+		t.Fatalf("ReadFrame() error = %v, want ErrEscapeAtEOF", err)
+	}
+}
+
+func TestDecodeUnrecognised(t *testing.T) {
+	_, err := Decode([]byte{0x99, 'x'})
+	if !errors.Is(err, ErrUnrecognised) {
+		t.Fatalf("Decode() error = %v, want ErrUnrecognised", err)
+	}
+}
+
+func TestReadFrameUnrecognised(t *testing.T) {
+	framed := Encode(rawMessage{code: 0x99, payload: []byte("x")})
+	d := NewDecoder(bytes.NewReader(framed))
+
+	_, err := d.ReadFrame()
+	if !errors.Is(err, ErrUnrecognised) {
+		t.Fatalf("ReadFrame() error = %v, want ErrUnrecognised", err)
+	}
+	if d.Stats.Unrecognised != 1 {
+		t.Fatalf("Stats.Unrecognised = %d, want 1", d.Stats.Unrecognised)
+	}
+}
+
+// rawMessage lets the test build a frame with an arbitrary, possibly
+// unrecognised Code, which none of the Message implementations in
+// framing.go allow.
+type rawMessage struct {
+	code    byte
+	payload []byte
+}
+
+func (m rawMessage) Code() Code      { return Code(m.code) }
+func (m rawMessage) Payload() []byte { return m.payload }
+
+func TestEncodeEscapesSentinels(t *testing.T) {
+	framed := Encode(Payload{Data: []byte{Mark, Esc}})
+	// Body is [CodePayload, Mark, Esc, 4 checksum bytes]; Mark and Esc must
+	// both be escaped, so none of the middle bytes may equal Mark.
+	for _, b := range framed[:len(framed)-1] {
+		if b == Mark {
+			t.Fatalf("unescaped Mark found before frame terminator: %x", framed)
+		}
+	}
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+    "encoding/json"
+    "flag"
     "fmt"
+    "github.com/Zokol/serial_stresstest/config"
+    "github.com/Zokol/serial_stresstest/delaystats"
+    "github.com/Zokol/serial_stresstest/framing"
+    "github.com/Zokol/serial_stresstest/search"
     "github.com/jacobsa/go-serial/serial"
+    "io"
+    "log/slog"
+    "os"
+    "strings"
+    "sync"
     "time"
-    "hash/crc32"
     "math/rand"
-    "strings"
-    "strconv"
 )
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
@@ -20,238 +28,445 @@ func randSeq(n int) string {
     return string(b)
 }
 
-func test_transmission(ports []string, length int, speed int) (bool, []float64) {
-    /*
-    test_transmission
+// PortPair is one transmit/receive path to exercise. RX equal to TX means a
+// single physical port with a hardware loopback; a distinct RX means two
+// ports joined by a crossover cable, tested end-to-end instead of assuming
+// the write lands back on the same handle.
+type PortPair struct {
+    TX string
+    RX string
+}
 
-    Sends packets of given length from all serial devices one by one, receiving with other devices.
-    Checks that the received packet CRC matches the one calculated for the sent payload.
+// PairResult is what one PortPair produced from a single test_transmission
+// call: whether it passed, the round-trip delay(s) it measured (two in
+// full-duplex mode, one otherwise), and the framing stats seen on its
+// receive side(s).
+type PairResult struct {
+    Pair   PortPair
+    OK     bool
+    Delays []time.Duration
+    Stats  framing.Stats
+}
 
-    Raises AssertionError if CRC is invalid
+func mergeFramingStats(a, b framing.Stats) framing.Stats {
+    return framing.Stats{
+        Received:     a.Received + b.Received,
+        Escaped:      a.Escaped + b.Escaped,
+        CheckErrors:  a.CheckErrors + b.CheckErrors,
+        ShortFrame:   a.ShortFrame + b.ShortFrame,
+        Unrecognised: a.Unrecognised + b.Unrecognised,
+    }
+}
 
-    Args:
-        length (int): Length of the random ASCII string to be sent as a payload
+func openSerialPort(name string, speed int) io.ReadWriteCloser {
+    options := serial.OpenOptions{
+        PortName:        name,
+        BaudRate:        uint(speed),
+        DataBits:        8,
+        StopBits:        1,
+        MinimumReadSize: 4,
+    }
+    conn, err := serial.Open(options)
+    check(err)
+    return conn
+}
 
-    Returns:
-        bool: If test is complete, returns True
+// sendAndVerify sends one framed payload of length bytes on tx, reads a
+// frame back from rx with the given timeout, and checks that it matches.
+// The underlying serial handle has no deadline support, so the timeout is
+// enforced by racing the blocking read against a timer in a goroutine; on
+// timeout that goroutine is left running until the peer eventually sends
+// something or the port is closed.
+func sendAndVerify(tx io.Writer, rx io.Reader, length int, timeout time.Duration) (bool, time.Duration, framing.Stats) {
+    data := []byte(randSeq(length))
+    msg := framing.Payload{Data: data}
+    packet := framing.Encode(msg)
 
-    */
+    dec := framing.NewDecoder(rx)
 
-    rand.Seed(time.Now().UnixNano())
+    type readResult struct {
+        frame []byte
+        err   error
+    }
+    done := make(chan readResult, 1)
+
+    start := time.Now()
+    tx.Write(packet)
+    go func() {
+        frame, err := dec.ReadFrame()
+        done <- readResult{frame, err}
+    }()
+
+    select {
+    case r := <-done:
+        elapsed := time.Since(start)
+        if r.err != nil {
+            return false, elapsed, dec.Stats
+        }
+        got, err := framing.Decode(r.frame)
+        if err != nil {
+            // ReadFrame already rejects frames with an unrecognised Code
+            // (bumping dec.Stats.Unrecognised itself), so reaching here
+            // with an error means frame is malformed in some other way.
+            return false, elapsed, dec.Stats
+        }
+        if got.Code() != msg.Code() || string(got.Payload()) != string(data) {
+            return false, elapsed, dec.Stats
+        }
+        return true, elapsed, dec.Stats
+    case <-time.After(timeout):
+        return false, timeout, dec.Stats
+    }
+}
 
-    data := []byte(randSeq(length))
+func runPair(pair PortPair, length int, speed int, timeout time.Duration, fullDuplex bool) PairResult {
+    txConn := openSerialPort(pair.TX, speed)
+    defer txConn.Close()
 
-    delays := make([]float64, 0)
+    rxConn := io.ReadWriteCloser(txConn)
+    if pair.RX != pair.TX {
+        rxConn = openSerialPort(pair.RX, speed)
+        defer rxConn.Close()
+    }
 
-    crc32q := crc32.MakeTable(0xEDB88320)
-    checksum_uint := crc32.Checksum(data, crc32q)
-    checksum := []byte(strconv.FormatUint(uint64(checksum_uint), 16))
-    packet := append(data, checksum...)
+    if !fullDuplex {
+        ok, elapsed, stats := sendAndVerify(txConn, rxConn, length, timeout)
+        var delays []time.Duration
+        if ok {
+            delays = append(delays, elapsed)
+        }
+        return PairResult{Pair: pair, OK: ok, Delays: delays, Stats: stats}
+    }
 
-    //fmt.Println("data:", string(data))
-    //fmt.Println("checksum:", string(checksum))
-    //fmt.Println("TX:", packet, "\n")
+    // Full-duplex: transmit in both directions at once, to catch
+    // flow-control and interrupt-latency bugs that only show up under
+    // bidirectional load.
+    var wg sync.WaitGroup
+    var fwdOK, revOK bool
+    var fwdElapsed, revElapsed time.Duration
+    var fwdStats, revStats framing.Stats
+
+    wg.Add(2)
+    go func() {
+        defer wg.Done()
+        fwdOK, fwdElapsed, fwdStats = sendAndVerify(txConn, rxConn, length, timeout)
+    }()
+    go func() {
+        defer wg.Done()
+        revOK, revElapsed, revStats = sendAndVerify(rxConn, txConn, length, timeout)
+    }()
+    wg.Wait()
+
+    var delays []time.Duration
+    if fwdOK {
+        delays = append(delays, fwdElapsed)
+    }
+    if revOK {
+        delays = append(delays, revElapsed)
+    }
 
-    for _, port := range ports {
+    return PairResult{Pair: pair, OK: fwdOK && revOK, Delays: delays, Stats: mergeFramingStats(fwdStats, revStats)}
+}
 
-        options := serial.OpenOptions{
-            PortName:        string(port),
-            BaudRate:        uint(speed),
-            DataBits:        8,
-            StopBits:        1,
-            MinimumReadSize: 4,
-        }
-        port, err := serial.Open(options)
-        check(err)
+func test_transmission(pairs []PortPair, length int, speed int, timeout time.Duration, fullDuplex bool) (bool, []PairResult) {
+    /*
+    test_transmission
 
-        buf := make([]byte, 10000)
+    Exercises every (txPort, rxPort) pair concurrently: writes a framed packet of length
+    bytes on txPort and reads it back on rxPort (or --full-duplex: writes and reads
+    simultaneously in both directions), checking that the received frame's CRC matches the
+    one calculated for the sent payload. A pair with RX == TX is a single physical port with
+    a hardware loopback; a distinct RX is two ports joined by a crossover cable, which is
+    what actually exercises the link end-to-end.
 
-        start := time.Now()
-        port.Write(packet)
-        port.Read(buf)
-        t := time.Now()
-        elapsed := t.Sub(start)
+    Args:
+        length (int): Length of the random ASCII string to be sent as a payload
 
-        defer port.Close()
+    Returns:
+        bool: True only if every pair passed
+        []PairResult: Per-pair outcome, delays and framing stats, for diagnostics
 
-        check(err)
+    */
 
-        //fmt.Println("RX:", buf[0:len(packet)])
+    rand.Seed(time.Now().UnixNano())
 
-        packet_valid := strings.HasSuffix(string(buf[0:len(packet)]), string(checksum))
+    results := make([]PairResult, len(pairs))
+    var wg sync.WaitGroup
+    wg.Add(len(pairs))
+    for i, pair := range pairs {
+        go func(i int, pair PortPair) {
+            defer wg.Done()
+            results[i] = runPair(pair, length, speed, timeout, fullDuplex)
+        }(i, pair)
+    }
+    wg.Wait()
 
-        if !packet_valid{
-            return false, delays
+    ok := true
+    for _, r := range results {
+        if !r.OK {
+            ok = false
         }
+    }
+
+    return ok, results
+}
 
-        //fmt.Println("Checksum match:", packet_valid)
+func flattenPairResults(results []PairResult) ([]time.Duration, framing.Stats) {
+    var delays []time.Duration
+    var stats framing.Stats
+    for _, r := range results {
+        delays = append(delays, r.Delays...)
+        stats = mergeFramingStats(stats, r.Stats)
+    }
+    return delays, stats
+}
 
-        //fmt.Println("Read", n)
-        //fmt.Println("Delay", elapsed)
-        delays = append(delays, float64(elapsed))
+func pairLabel(pairs []PortPair) string {
+    labels := make([]string, len(pairs))
+    for i, p := range pairs {
+        if p.TX == p.RX {
+            labels[i] = p.TX
+        } else {
+            labels[i] = p.TX + "->" + p.RX
+        }
     }
+    return strings.Join(labels, ",")
+}
+
+// standardBaudRates are the candidate speeds test_for_speed snaps to, since
+// the UART cannot actually generate an arbitrary baudrate between these.
+var standardBaudRates = []int{9600, 19200, 38400, 57600, 115200, 230400, 460800, 921600}
 
-    return true, delays
+func snapToStandardBaudRate(candidate int) int {
+    return search.Nearest(candidate, standardBaudRates)
 }
 
-func test_for_speed(ports []string, length int, min_speed int, max_speed int) int{
+func test_for_speed(pairs []PortPair, length int, min_speed int, max_speed int, reps int, timeout time.Duration, fullDuplex bool) (int, []search.Step) {
     /*
     test_for_speed
 
-    Runs iterative process to find the highest working speed
-
-    Speed is increased after every successfull test by the relation of the min and max of the test range
+    Finds the highest working speed with an exponential probe (doubling from min_speed)
+    followed by a bisection between the last-good and first-bad speed, snapping every
+    candidate to the nearest standardBaudRates entry since the UART only generates those.
+    Each candidate is validated with `reps` repetitions before being accepted, since a
+    single transmission test is flaky.
 
     Args:
-        device_paths (list of strings): Paths to the serial devices to be used in testing
+        pairs ([]PortPair): tx/rx port pairs to be used in testing
         length (int): Length of the random ASCII string to be sent as a payload
         min_speed (int): Minimum limit for speed test
         max_speed (int): Maximum limit for speed test
+        reps (int): Number of repetitions a candidate speed must pass to be accepted
 
     Returns:
-        int: Last known speed that resulted in successfull test
+        int: Highest speed that passed all reps
+        []search.Step: Full probe history, for diagnostics
     */
-    var res = false
-    var last_working_speed = 0
-    for i := 0; i < 2; i++ {
-        if (max_speed / min_speed) < 1{
-            return last_working_speed
-        }
-        for speed := min_speed; speed < max_speed; speed = speed + int(max_speed / min_speed) {
-            //fmt.Println("Testing for speed:", speed, "with packet length:", length)
-            res, _ := test_transmission(ports, length, speed)
-            if (res == true){
-                last_working_speed = speed
-            } else {
-                //return last_working_speed
-                min_speed = speed
-                break
+
+    probe := func(speed int) bool {
+        for i := 0; i < reps; i++ {
+            res, _ := test_transmission(pairs, length, speed, timeout, fullDuplex)
+            if !res {
+                return false
             }
         }
-        if (res == true){
-            return last_working_speed
-        }
+        return true
     }
-    return last_working_speed
+
+    result := search.FindHighest(min_speed, max_speed, probe, snapToStandardBaudRate)
+    return result.Best, result.History
 }
 
-func test_for_length(ports []string, speed int, min_length int, max_length int) int{
+func test_for_length(pairs []PortPair, speed int, min_length int, max_length int, reps int, timeout time.Duration, fullDuplex bool) (int, []search.Step) {
     /*
     test_for_length
 
-    Runs iterative process to find the highest working packet length
-
-    Length is increased after every successfull test by the relation of the min and max of the test range
+    Finds the highest working packet length with an exponential probe (doubling from
+    min_length) followed by a bisection between the last-good and first-bad length. Each
+    candidate is validated with `reps` repetitions before being accepted, since a single
+    transmission test is flaky.
 
     Args:
-        device_paths (list of strings): Paths to the serial devices to be used in testing
+        pairs ([]PortPair): tx/rx port pairs to be used in testing
         speed (int): Baudrate used in transmission test
         min_length (int): Minimum limit for length test
         max_length (int): Maximum limit for length test
+        reps (int): Number of repetitions a candidate length must pass to be accepted
 
     Returns:
-        int: Last known length that resulted in successfull test
+        int: Highest length that passed all reps
+        []search.Step: Full probe history, for diagnostics
     */
-    var last_working_length = 0
-    for i := 0; i < 2; i++ {
-        for length := min_length; length < max_length; length++ {
-            //fmt.Println("Testing for packet length:", length, "with speed:", speed)
-            res, _ := test_transmission(ports, length, speed)
-            if (res == true){
-                last_working_length = length
-                continue
-            } else {
-                return last_working_length
+
+    probe := func(length int) bool {
+        for i := 0; i < reps; i++ {
+            res, _ := test_transmission(pairs, length, speed, timeout, fullDuplex)
+            if !res {
+                return false
             }
         }
+        return true
     }
-    return last_working_length
+
+    result := search.FindHighest(min_length, max_length, probe, func(v int) int { return v })
+    return result.Best, result.History
 }
 
-func test_for_delay(ports []string, length int, speed int, samples int) float64{
+func test_for_delay(logger *slog.Logger, pairs []PortPair, length int, speed int, samples int, timeout time.Duration, fullDuplex bool) (delaystats.DelayReport, error) {
     /*
     test_for_delay
 
-    Runs several samples with given speed and packet length to find average delay between send and receive
+    Runs several samples with given speed and packet length and reports the full delay
+    distribution between send and receive, including the tail percentiles that matter for
+    serial links (occasional stalls under load are the interesting failure mode, and they
+    don't show up in a plain min/mean/max).
 
     Args:
-        device_paths (list of strings): Paths to the serial devices to be used in testing
+        pairs ([]PortPair): tx/rx port pairs to be used in testing
         speed (int): Baudrate used in transmission test
         length (int): Transmission packet length
         number_of_samples (int): Number of samples to be taken
 
     Returns:
-        int: Maximum delay from all transmission samples
+        delaystats.DelayReport: Percentile report over all collected samples
     */
 
-    delays := make([]float64, 0)
+    label := pairLabel(pairs)
+
+    delays := make([]time.Duration, 0)
     for i := 0; i < samples; i++ {
-        //fmt.Println("Testing comm delay with packet length:", length, "and speed:", speed)
-        //start := time.Now()
-        res, d := test_transmission(ports, length, speed)
-        //fmt.Println(res, d)
+        logger.Debug("sampling delay", "pairs", label, "speed", speed, "length", length, "iteration", i)
+        res, results := test_transmission(pairs, length, speed, timeout, fullDuplex)
         if !(res){
             break
         }
-        //t := time.Now()
-        //elapsed := t.Sub(start)
-        
+
+        d, _ := flattenPairResults(results)
         delays = append(delays, d...)
     }
 
-    var total float64 = 0
-    var min_delay float64 = 0
-    var max_delay float64 = 0
-    for _, value := range delays {
-        total += value
-        if min_delay == 0{
-            min_delay = value
-        }
-        if max_delay == 0{
-            max_delay = value
+    return delaystats.Report(delays)
+}
+
+func check(err error) {
+    if err != nil {
+        panic(err.Error())
+    }
+}
+
+// PairSummary is the pass/fail outcome and framing stats for one PortPair,
+// as surfaced in PlanResult.
+type PairSummary struct {
+    TX    string        `json:"tx"`
+    RX    string        `json:"rx"`
+    OK    bool          `json:"ok"`
+    Stats framing.Stats `json:"stats"`
+}
+
+// PlanResult is the JSON output line for a test plan run: the search
+// results for speed and length, delay reports at both extremes, and a
+// per-pair breakdown across every configured tx/rx pair.
+type PlanResult struct {
+    Speed          int                    `json:"speed"`
+    SpeedHistory   []search.Step          `json:"speed_history"`
+    Length         int                    `json:"length"`
+    LengthHistory  []search.Step          `json:"length_history"`
+    MinLengthDelay delaystats.DelayReport `json:"min_length_delay"`
+    MaxLengthDelay delaystats.DelayReport `json:"max_length_delay"`
+    Pairs          []PairSummary          `json:"pairs"`
+}
+
+func runPlan(logger *slog.Logger, plan config.Plan) (PlanResult, error) {
+    min_speed, max_speed := plan.BaudRates[0], plan.BaudRates[0]
+    for _, b := range plan.BaudRates {
+        if b < min_speed {
+            min_speed = b
         }
-        if min_delay > value{
-            min_delay = value
+        if b > max_speed {
+            max_speed = b
         }
-        if max_delay < value {
-            max_delay = value
+    }
+
+    pairs := make([]PortPair, len(plan.PortPairs))
+    for i, p := range plan.PortPairs {
+        pairs[i] = PortPair{TX: p.TX, RX: p.RX}
+    }
+
+    timeout, fullDuplex := plan.Test.Timeout, plan.Test.FullDuplex
+
+    if fullDuplex {
+        for _, p := range pairs {
+            if p.TX == p.RX {
+                return PlanResult{}, fmt.Errorf("run: full_duplex requires distinct tx/rx ports, got self-loopback pair %q", p.TX)
+            }
         }
     }
 
-    var avg_delay = total/float64(len(delays))
+    speed, speedHistory := test_for_speed(pairs, plan.Test.MinPayloadLength, min_speed, max_speed, plan.Test.Retries, timeout, fullDuplex)
+    logger.Info("found working speed", "pairs", pairLabel(pairs), "speed", speed)
 
-    fmt.Println("Maximum delay:", max_delay, "ms")
-    fmt.Println("Average delay:", avg_delay, "ms")
-    fmt.Println("Minimum delay:", min_delay, "ms")
+    length, lengthHistory := test_for_length(pairs, speed, plan.Test.MinPayloadLength, plan.Test.MaxPayloadLength, plan.Test.Retries, timeout, fullDuplex)
+    logger.Info("found working length", "pairs", pairLabel(pairs), "speed", speed, "length", length)
 
-    return avg_delay
-}
+    minLengthDelay, err := test_for_delay(logger, pairs, plan.Test.MinPayloadLength, speed, plan.Test.Samples, timeout, fullDuplex)
+    if err != nil {
+        return PlanResult{}, fmt.Errorf("delay report at min length: %w", err)
+    }
+    logger.Info("delay report", "pairs", pairLabel(pairs), "speed", speed, "length", plan.Test.MinPayloadLength, "summary", minLengthDelay.Summary())
 
-func check(err error) {
+    maxLengthDelay, err := test_for_delay(logger, pairs, length, speed, plan.Test.Samples, timeout, fullDuplex)
     if err != nil {
-        panic(err.Error())
+        return PlanResult{}, fmt.Errorf("delay report at max length: %w", err)
     }
+    logger.Info("delay report", "pairs", pairLabel(pairs), "speed", speed, "length", length, "summary", maxLengthDelay.Summary())
+
+    _, pairResults := test_transmission(pairs, length, speed, timeout, fullDuplex)
+    summaries := make([]PairSummary, len(pairResults))
+    for i, r := range pairResults {
+        summaries[i] = PairSummary{TX: r.Pair.TX, RX: r.Pair.RX, OK: r.OK, Stats: r.Stats}
+        logger.Info("pair result", "tx", r.Pair.TX, "rx", r.Pair.RX, "ok", r.OK)
+    }
+
+    return PlanResult{
+        Speed:          speed,
+        SpeedHistory:   speedHistory,
+        Length:         length,
+        LengthHistory:  lengthHistory,
+        MinLengthDelay: minLengthDelay,
+        MaxLengthDelay: maxLengthDelay,
+        Pairs:          summaries,
+    }, nil
 }
 
 func main() {
-    var serials = []string{"COM15"}
+    configPath := flag.String("config", "", "path to a YAML test plan describing ports, baud rates and test parameters")
+    fullDuplex := flag.Bool("full-duplex", false, "transmit in both directions simultaneously, overriding the plan's full_duplex setting")
+    flag.Parse()
 
-    //test_transmission(serials, 23, 9600)
-    //fmt.Println(test_for_delay(serials, 10, 9600, 10), "ms")
+    if *configPath == "" {
+        fmt.Fprintln(os.Stderr, "usage: serial_stresstest -config plan.yaml")
+        os.Exit(2)
+    }
+
+    plan, err := config.Load(*configPath)
+    check(err)
+    if *fullDuplex {
+        plan.Test.FullDuplex = true
+    }
 
-    
-    last_working_speed := test_for_speed(serials, 20, 9600, 1000000)
-    fmt.Println("Last known working speed:", last_working_speed)
-    
-    last_working_length := test_for_length(serials, last_working_speed, 100, 100000)
-    fmt.Println("Last known working length:", last_working_length)
+    logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
 
-    avg_delay := test_for_delay(serials, 10, last_working_speed, 10)
-    fmt.Println("Average delay:", avg_delay, "ms for packet length:", 10)
+    var sink *os.File = os.Stdout
+    if plan.Output.JSONLPath != "" {
+        sink, err = os.Create(plan.Output.JSONLPath)
+        check(err)
+        defer sink.Close()
+    }
 
-    avg_delay = test_for_delay(serials, last_working_length, last_working_speed, 10)
-    fmt.Println("Average delay:", avg_delay, "ms for packet length:", last_working_length)
+    result, err := runPlan(logger, plan)
+    check(err)
 
-    
-    
+    line, err := json.Marshal(result)
+    check(err)
+    fmt.Fprintln(sink, string(line))
 }
\ No newline at end of file
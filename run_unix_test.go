@@ -0,0 +1,195 @@
+//go:build !windows
+
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zokol/serial_stresstest/config"
+	"github.com/Zokol/serial_stresstest/virtualport"
+	"github.com/jacobsa/go-serial/serial"
+)
+
+func slogTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// requirePTYSupport skips the test if this environment's ptys don't
+// support the termios ioctls jacobsa/go-serial relies on (seen e.g. under
+// some sandboxed kernels), since that's an environment limitation rather
+// than something these tests are meant to catch.
+func requirePTYSupport(t *testing.T) {
+	t.Helper()
+
+	path, cleanup, err := virtualport.Loopback()
+	if err != nil {
+		t.Skipf("virtual serial port unavailable: %v", err)
+	}
+	defer cleanup()
+
+	_, err = serial.Open(serial.OpenOptions{
+		PortName:        path,
+		BaudRate:        9600,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 4,
+	})
+	if err != nil {
+		t.Skipf("serial.Open on a virtual pty is unsupported in this environment: %v", err)
+	}
+}
+
+func TestTestTransmissionLoopback(t *testing.T) {
+	requirePTYSupport(t)
+	cases := []struct {
+		name   string
+		length int
+	}{
+		{"short payload", 8},
+		{"long payload", 2000},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path, cleanup, err := virtualport.Loopback()
+			if err != nil {
+				t.Fatalf("Loopback() error = %v", err)
+			}
+			defer cleanup()
+
+			pair := PortPair{TX: path, RX: path}
+			ok, results := test_transmission([]PortPair{pair}, c.length, 9600, time.Second, false)
+			if !ok {
+				t.Fatalf("test_transmission() ok = false, results = %+v", results)
+			}
+			if len(results) != 1 || !results[0].OK || len(results[0].Delays) != 1 {
+				t.Fatalf("results = %+v, want one passing pair with one delay", results)
+			}
+		})
+	}
+}
+
+func TestTestTransmissionCrossover(t *testing.T) {
+	requirePTYSupport(t)
+	txPath, rxPath, cleanup, err := virtualport.Bridge()
+	if err != nil {
+		t.Fatalf("Bridge() error = %v", err)
+	}
+	defer cleanup()
+
+	pair := PortPair{TX: txPath, RX: rxPath}
+	ok, results := test_transmission([]PortPair{pair}, 64, 115200, time.Second, false)
+	if !ok {
+		t.Fatalf("test_transmission() ok = false, results = %+v", results)
+	}
+}
+
+func TestTestTransmissionFullDuplex(t *testing.T) {
+	requirePTYSupport(t)
+	txPath, rxPath, cleanup, err := virtualport.Bridge()
+	if err != nil {
+		t.Fatalf("Bridge() error = %v", err)
+	}
+	defer cleanup()
+
+	pair := PortPair{TX: txPath, RX: rxPath}
+	ok, results := test_transmission([]PortPair{pair}, 32, 115200, time.Second, true)
+	if !ok {
+		t.Fatalf("test_transmission(fullDuplex) ok = false, results = %+v", results)
+	}
+	if len(results) != 1 || len(results[0].Delays) != 2 {
+		t.Fatalf("results = %+v, want one pair with two delays (forward and reverse)", results)
+	}
+}
+
+func TestTestTransmissionTimeout(t *testing.T) {
+	requirePTYSupport(t)
+	path, cleanup, err := virtualport.LoopbackWithLengthLimit(0)
+	if err != nil {
+		t.Fatalf("LoopbackWithLengthLimit() error = %v", err)
+	}
+	defer cleanup()
+
+	pair := PortPair{TX: path, RX: path}
+	ok, results := test_transmission([]PortPair{pair}, 16, 9600, 100*time.Millisecond, false)
+	if ok {
+		t.Fatalf("test_transmission() ok = true, want false for a dropped frame, results = %+v", results)
+	}
+}
+
+func TestTestForLengthWithThreshold(t *testing.T) {
+	requirePTYSupport(t)
+	const limit = 50
+
+	path, cleanup, err := virtualport.LoopbackWithLengthLimit(limit)
+	if err != nil {
+		t.Fatalf("LoopbackWithLengthLimit() error = %v", err)
+	}
+	defer cleanup()
+
+	pair := PortPair{TX: path, RX: path}
+	length, history := test_for_length([]PortPair{pair}, 9600, 1, 1000, 1, time.Second, false)
+
+	if length != limit {
+		t.Fatalf("test_for_length() = %d, want %d", length, limit)
+	}
+	if len(history) == 0 {
+		t.Fatal("test_for_length() history is empty, want at least one probed candidate")
+	}
+}
+
+func TestTestForDelay(t *testing.T) {
+	requirePTYSupport(t)
+	path, cleanup, err := virtualport.Loopback()
+	if err != nil {
+		t.Fatalf("Loopback() error = %v", err)
+	}
+	defer cleanup()
+
+	logger := slogTestLogger()
+	pair := PortPair{TX: path, RX: path}
+	report, err := test_for_delay(logger, []PortPair{pair}, 16, 9600, 5, time.Second, false)
+	if err != nil {
+		t.Fatalf("test_for_delay() error = %v", err)
+	}
+	if len(report.Samples) != 5 {
+		t.Fatalf("len(report.Samples) = %d, want 5", len(report.Samples))
+	}
+	if report.Max < report.Min {
+		t.Fatalf("report.Max = %s, want >= report.Min = %s", report.Max, report.Min)
+	}
+}
+
+// TestRunPlanRejectsFullDuplexSelfLoopback guards against the pair of
+// goroutines spawned by runPair's full-duplex branch racing to read the
+// same underlying file handle: with TX == RX there is only one handle, so
+// each side's framing.Decoder would consume an arbitrary interleaved
+// subset of both directions' bytes instead of its own frames.
+func TestRunPlanRejectsFullDuplexSelfLoopback(t *testing.T) {
+	logger := slogTestLogger()
+	plan := config.Plan{
+		Ports:     []string{"/dev/ttyUSB0"},
+		BaudRates: []int{9600},
+		Test: config.TestParams{
+			MinPayloadLength: 8,
+			MaxPayloadLength: 8,
+			Samples:          1,
+			Retries:          1,
+			Timeout:          time.Second,
+			FullDuplex:       true,
+		},
+	}
+	plan.PortPairs = []config.PortPair{{TX: "/dev/ttyUSB0", RX: "/dev/ttyUSB0"}}
+
+	_, err := runPlan(logger, plan)
+	if err == nil {
+		t.Fatal("runPlan() error = nil, want error for full-duplex self-loopback pair")
+	}
+	if !strings.Contains(err.Error(), "full_duplex") {
+		t.Fatalf("runPlan() error = %q, want mention of full_duplex", err)
+	}
+}
@@ -0,0 +1,106 @@
+// Package search implements a generic exponential-probe-then-bisect search
+// for the highest integer value in a range that satisfies a caller-supplied
+// probe, used to find the fastest working baudrate or the longest working
+// packet length without the cost of a linear sweep.
+package search
+
+// Step records one probed candidate and whether it passed, so callers can
+// surface the search path for diagnostics.
+type Step struct {
+	Candidate int
+	Passed    bool
+}
+
+// Result is the outcome of FindHighest: the highest candidate that passed
+// (0 if none did), and the full probe history in the order it was tried.
+type Result struct {
+	Best    int
+	History []Step
+}
+
+// FindHighest searches [min, max] for the highest candidate for which probe
+// returns true. It first doubles the candidate from min until probe fails
+// or max is reached (exponential probe), then bisects between the
+// last-good and first-bad candidates. Every candidate is passed through
+// snap before being probed, so the search only ever lands on snap's output
+// (e.g. the nearest value in a fixed set of standard baudrates); pass the
+// identity function if candidates need no snapping.
+func FindHighest(min, max int, probe func(int) bool, snap func(int) int) Result {
+	var history []Step
+
+	try := func(candidate int) bool {
+		passed := probe(candidate)
+		history = append(history, Step{Candidate: candidate, Passed: passed})
+		return passed
+	}
+
+	lastGood, firstBad := -1, -1
+
+	candidate := snap(min)
+	for {
+		if candidate > max {
+			candidate = max
+		}
+		if !try(candidate) {
+			firstBad = candidate
+			break
+		}
+		lastGood = candidate
+		if candidate >= max {
+			break
+		}
+		next := candidate * 2
+		if next <= candidate {
+			// candidate is 0 or negative; step linearly instead of stalling.
+			next = candidate + 1
+		}
+		candidate = snap(next)
+	}
+
+	if firstBad == -1 || lastGood == -1 {
+		best := lastGood
+		if best == -1 {
+			best = 0
+		}
+		return Result{Best: best, History: history}
+	}
+
+	for firstBad-lastGood > 1 {
+		mid := snap(lastGood + (firstBad-lastGood)/2)
+		if mid <= lastGood || mid >= firstBad {
+			break
+		}
+		if try(mid) {
+			lastGood = mid
+		} else {
+			firstBad = mid
+		}
+	}
+
+	return Result{Best: lastGood, History: history}
+}
+
+// Nearest returns the value in candidates closest to target. It returns
+// target unchanged if candidates is empty.
+func Nearest(target int, candidates []int) int {
+	if len(candidates) == 0 {
+		return target
+	}
+
+	best := candidates[0]
+	bestDiff := abs(target - best)
+	for _, c := range candidates[1:] {
+		if diff := abs(target - c); diff < bestDiff {
+			best = c
+			bestDiff = diff
+		}
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,79 @@
+package search
+
+import "testing"
+
+func identity(v int) int { return v }
+
+func TestFindHighestThresholdInRange(t *testing.T) {
+	probe := func(c int) bool { return c <= 737 }
+
+	result := FindHighest(1, 1000000, probe, identity)
+	if result.Best != 737 {
+		t.Fatalf("Best = %d, want 737", result.Best)
+	}
+	if len(result.History) == 0 {
+		t.Fatal("History is empty, want at least one probed candidate")
+	}
+}
+
+func TestFindHighestEverythingPasses(t *testing.T) {
+	result := FindHighest(10, 1000, func(int) bool { return true }, identity)
+	if result.Best != 1000 {
+		t.Fatalf("Best = %d, want max 1000", result.Best)
+	}
+}
+
+func TestFindHighestNothingPasses(t *testing.T) {
+	result := FindHighest(10, 1000, func(int) bool { return false }, identity)
+	if result.Best != 0 {
+		t.Fatalf("Best = %d, want 0", result.Best)
+	}
+}
+
+func TestFindHighestSnapsToStandardSet(t *testing.T) {
+	standard := []int{9600, 19200, 38400, 57600, 115200, 230400, 460800, 921600}
+	snap := func(v int) int { return Nearest(v, standard) }
+	probe := func(c int) bool { return c <= 100000 }
+
+	result := FindHighest(9600, 1000000, probe, snap)
+	if result.Best != 57600 {
+		t.Fatalf("Best = %d, want 57600", result.Best)
+	}
+	for _, step := range result.History {
+		found := false
+		for _, s := range standard {
+			if step.Candidate == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("candidate %d is not a standard baudrate", step.Candidate)
+		}
+	}
+}
+
+func TestNearest(t *testing.T) {
+	cases := []struct {
+		target int
+		want   int
+	}{
+		{9600, 9600},
+		{100000, 115200},
+		{50000, 57600},
+		{1000000, 921600},
+	}
+	standard := []int{9600, 19200, 38400, 57600, 115200, 230400, 460800, 921600}
+
+	for _, c := range cases {
+		if got := Nearest(c.target, standard); got != c.want {
+			t.Errorf("Nearest(%d) = %d, want %d", c.target, got, c.want)
+		}
+	}
+}
+
+func TestNearestEmptySet(t *testing.T) {
+	if got := Nearest(42, nil); got != 42 {
+		t.Fatalf("Nearest(42, nil) = %d, want 42", got)
+	}
+}
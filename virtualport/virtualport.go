@@ -0,0 +1,106 @@
+//go:build !windows
+
+// Package virtualport provides pseudo-terminal backed serial ports for
+// tests, so the stress tester's core logic can be exercised in CI without
+// real hardware attached.
+package virtualport
+
+import (
+	"io"
+
+	"github.com/creack/pty"
+
+	"github.com/Zokol/serial_stresstest/framing"
+)
+
+// Loopback opens a pseudo-terminal and starts a goroutine that copies
+// whatever is written to it straight back, so the returned slave path
+// behaves like a single serial port with a physical TX-to-RX hardware
+// loopback wired. Call the returned cleanup func to stop the echo and
+// release the pty.
+func Loopback() (path string, cleanup func() error, err error) {
+	master, slave, err := pty.Open()
+	if err != nil {
+		return "", nil, err
+	}
+
+	go io.Copy(master, master)
+
+	return slave.Name(), closeBoth(master, slave), nil
+}
+
+// LoopbackWithLengthLimit behaves like Loopback, except it decodes each
+// frame and only echoes it back if its payload is no longer than maxLen.
+// Longer frames are silently dropped, standing in for a UUT that cannot
+// handle payloads past a given length, so length-search tests can exercise
+// a real failure threshold without real hardware.
+func LoopbackWithLengthLimit(maxLen int) (path string, cleanup func() error, err error) {
+	master, slave, err := pty.Open()
+	if err != nil {
+		return "", nil, err
+	}
+
+	go func() {
+		dec := framing.NewDecoder(master)
+		for {
+			frame, err := dec.ReadFrame()
+			if err != nil {
+				return
+			}
+			msg, err := framing.Decode(frame)
+			if err != nil {
+				continue
+			}
+			if len(msg.Payload()) > maxLen {
+				continue
+			}
+			if _, err := master.Write(framing.Encode(msg)); err != nil {
+				return
+			}
+		}
+	}()
+
+	return slave.Name(), closeBoth(master, slave), nil
+}
+
+// Bridge opens two pseudo-terminals and copies data between their masters
+// in both directions, so the two returned slave paths behave like two
+// serial ports joined by a crossover cable: whatever is written to one is
+// readable from the other.
+func Bridge() (txPath, rxPath string, cleanup func() error, err error) {
+	masterA, slaveA, err := pty.Open()
+	if err != nil {
+		return "", "", nil, err
+	}
+	masterB, slaveB, err := pty.Open()
+	if err != nil {
+		masterA.Close()
+		slaveA.Close()
+		return "", "", nil, err
+	}
+
+	go io.Copy(masterB, masterA)
+	go io.Copy(masterA, masterB)
+
+	cleanup = func() error {
+		errA := closeBoth(masterA, slaveA)()
+		errB := closeBoth(masterB, slaveB)()
+		if errA != nil {
+			return errA
+		}
+		return errB
+	}
+
+	return slaveA.Name(), slaveB.Name(), cleanup, nil
+}
+
+func closeBoth(master, slave io.Closer) func() error {
+	return func() error {
+		slaveErr := slave.Close()
+		masterErr := master.Close()
+		if slaveErr != nil {
+			return slaveErr
+		}
+		return masterErr
+	}
+}